@@ -0,0 +1,124 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by an attempt that was skipped because the
+// instance's circuit breaker is open.
+var ErrCircuitOpen = errors.New("client: circuit open, instance skipped")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+/*
+circuitBreaker guards a single servicePool against Send's retry loop
+hammering an instance that has already shown it's unreachable. Once
+defaultBreakerThreshold consecutive transport failures trip it, the breaker
+goes open and attemptSend skips the instance for defaultBreakerCooldown.
+After the cooldown it lets a single half-open probe through; a successful
+probe closes the breaker, a failed one reopens it for another cooldown. This
+lets an ejected instance that recovers reopen on its own, without waiting on
+a doozer InstanceRemove/Add cycle.
+*/
+type circuitBreaker struct {
+	mu            sync.Mutex
+	state         circuitState
+	failures      int
+	threshold     int
+	cooldown      time.Duration
+	openedAt      time.Time
+	probeInFlight bool
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		threshold: defaultBreakerThreshold,
+		cooldown:  defaultBreakerCooldown,
+	}
+}
+
+// allow reports whether a request may be attempted against the instance
+// right now, transitioning a long-open breaker into half-open and reserving
+// the single probe slot as it does.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probeInFlight = true
+		return true
+	case circuitHalfOpen:
+		if b.probeInFlight {
+			return false
+		}
+		b.probeInFlight = true
+		return true
+	}
+	return true
+}
+
+// record reports the outcome of a transport-level attempt (an application
+// error returned by the remote method doesn't count; the transport worked
+// fine) and returns the before/after state so the caller can log a
+// transition.
+func (b *circuitBreaker) record(transportErr bool) (from, to circuitState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	from = b.state
+	b.probeInFlight = false
+
+	if transportErr {
+		b.failures++
+		if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+			b.state = circuitOpen
+			b.openedAt = time.Now()
+		}
+	} else {
+		b.failures = 0
+		b.state = circuitClosed
+	}
+
+	to = b.state
+	return
+}
+
+// CircuitStateChange is logged via ServiceClient.Log whenever recording an
+// attempt's outcome changes a servicePool's breaker state: it trips, a probe
+// reopens it, or it recovers. The move from open to half-open happens inside
+// allow and is not logged.
+type CircuitStateChange struct {
+	Instance string
+	From     string
+	To       string
+}
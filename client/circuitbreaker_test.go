@@ -0,0 +1,118 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripsAfterThreshold(t *testing.T) {
+	b := newCircuitBreaker()
+	b.threshold = 3
+
+	for i := 0; i < b.threshold-1; i++ {
+		if !b.allow() {
+			t.Fatalf("allow() = false before threshold reached (failure %d)", i)
+		}
+		from, to := b.record(true)
+		if to != circuitClosed {
+			t.Fatalf("record(true) #%d = %v, want still closed", i, to)
+		}
+		_ = from
+	}
+
+	if !b.allow() {
+		t.Fatal("allow() = false on the tripping failure itself")
+	}
+	_, to := b.record(true)
+	if to != circuitOpen {
+		t.Fatalf("state after threshold failures = %v, want open", to)
+	}
+}
+
+func TestCircuitBreakerOpenSkipsUntilCooldown(t *testing.T) {
+	b := newCircuitBreaker()
+	b.threshold = 1
+	b.cooldown = 50 * time.Millisecond
+
+	b.allow()
+	b.record(true)
+	if b.state != circuitOpen {
+		t.Fatalf("state = %v, want open", b.state)
+	}
+
+	if b.allow() {
+		t.Fatal("allow() = true before cooldown elapsed")
+	}
+
+	time.Sleep(b.cooldown + 10*time.Millisecond)
+
+	if !b.allow() {
+		t.Fatal("allow() = false after cooldown elapsed, want a half-open probe")
+	}
+	if b.state != circuitHalfOpen {
+		t.Fatalf("state = %v, want half-open", b.state)
+	}
+	if b.allow() {
+		t.Fatal("allow() = true for a second concurrent probe while one is in flight")
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbeOutcomes(t *testing.T) {
+	t.Run("success closes", func(t *testing.T) {
+		b := newCircuitBreaker()
+		b.threshold = 1
+		b.cooldown = 0
+
+		b.allow()
+		b.record(true) // closed -> open
+		b.allow()      // open -> half-open, reserves the probe
+
+		from, to := b.record(false)
+		if from != circuitHalfOpen {
+			t.Fatalf("from = %v, want half-open", from)
+		}
+		if to != circuitClosed {
+			t.Fatalf("to = %v, want closed", to)
+		}
+	})
+
+	t.Run("failure reopens", func(t *testing.T) {
+		b := newCircuitBreaker()
+		b.threshold = 1
+		b.cooldown = 0
+
+		b.allow()
+		b.record(true) // closed -> open
+		b.allow()      // open -> half-open
+
+		from, to := b.record(true)
+		if from != circuitHalfOpen {
+			t.Fatalf("from = %v, want half-open", from)
+		}
+		if to != circuitOpen {
+			t.Fatalf("to = %v, want open", to)
+		}
+	})
+}
+
+func TestCircuitBreakerSuccessResetsFailureCount(t *testing.T) {
+	b := newCircuitBreaker()
+	b.threshold = 3
+
+	b.allow()
+	b.record(true)
+	b.allow()
+	b.record(true)
+	if b.failures != 2 {
+		t.Fatalf("failures = %d, want 2", b.failures)
+	}
+
+	b.allow()
+	b.record(false)
+	if b.failures != 0 {
+		t.Fatalf("failures after success = %d, want 0", b.failures)
+	}
+	if b.state != circuitClosed {
+		t.Fatalf("state after success = %v, want closed", b.state)
+	}
+}
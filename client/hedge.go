@@ -0,0 +1,204 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHedgeBudgetWindow = 10 * time.Second
+	// always allow at least this many hedges per window, even if the
+	// fraction alone would say no; otherwise a quiet service could never
+	// accumulate enough traffic to earn its first hedge.
+	minHedgesPerWindow = 1
+
+	latencyWindowSize = 256
+
+	// used when hedging is enabled but there's neither a latency sample nor
+	// a configured retry interval to fall back on yet
+	defaultHedgeFallback = 50 * time.Millisecond
+)
+
+// hedgeInterval picks how long to wait before firing a hedged attempt: the
+// configured percentile of recently observed latencies, falling back to the
+// client's retry interval and finally to defaultHedgeFallback until enough
+// samples have been collected.
+func hedgeInterval(hedge *hedgePolicy, retry time.Duration) time.Duration {
+	if d := hedge.latencies.percentile(hedge.percentile); d > 0 {
+		return d
+	}
+	if retry > 0 {
+		return retry
+	}
+	return defaultHedgeFallback
+}
+
+/*
+hedgePolicy configures ServiceClient.send's adaptive hedging: a second
+attempt is fired at whichever instance the client's balancer picks next once
+the first has been outstanding longer than the recent tail latency for this
+client, capped by a retry budget so hedging can't multiply load across every
+instance under overload. The balancer can pick the same instance again.
+*/
+type hedgePolicy struct {
+	percentile float64
+	maxHedges  int
+
+	latencies *latencyWindow
+	budget    *retryBudget
+}
+
+func newHedgePolicy(percentile, budgetFraction float64, maxHedges int) *hedgePolicy {
+	return &hedgePolicy{
+		percentile: percentile,
+		maxHedges:  maxHedges,
+		latencies:  newLatencyWindow(),
+		budget:     newRetryBudget(budgetFraction, defaultHedgeBudgetWindow),
+	}
+}
+
+/*
+ServiceClient.SetHedgePolicy() enables adaptive request hedging in place of
+the fixed retry ticker configured by SetTimeout: once the first attempt has
+been outstanding longer than the percentile-th percentile (e.g. 0.95 for
+P95) of this client's recently observed latencies, a hedged attempt is sent
+to the instance the balancer picks next (with lb.ConsistentHash that is the
+same instance as the first attempt), provided hedged requests would stay within
+budgetFraction of all requests sent over the last 10 seconds and fewer than
+maxHedges hedges have already gone out for this call. Passing maxHedges <= 0
+disables hedging and reverts to the retry ticker.
+*/
+func (c *ServiceClient) SetHedgePolicy(percentile, budgetFraction float64, maxHedges int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if maxHedges <= 0 {
+		c.hedge = nil
+		return
+	}
+	c.hedge = newHedgePolicy(percentile, budgetFraction, maxHedges)
+}
+
+// latencyWindow keeps a small rolling sample of recent attempt latencies and
+// answers percentile queries against it. It's deliberately a plain sorted
+// sample rather than a t-digest - good enough to pick a sane hedge
+// threshold without pulling in a histogram dependency.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, latencyWindowSize)}
+}
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next%latencyWindowSize] = d
+	w.next++
+}
+
+// percentile returns the p-th percentile (0 < p < 1) of the current sample,
+// or 0 if nothing has been observed yet.
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(p * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// retryBudget caps hedged requests to a fraction of total requests over a
+// sliding window of one-second buckets, the same shape gRPC and Finagle use
+// for their retry budgets.
+type retryBudget struct {
+	mu       sync.Mutex
+	fraction float64
+	window   time.Duration
+	buckets  map[int64]*budgetBucket
+}
+
+type budgetBucket struct {
+	total  int
+	hedged int
+}
+
+func newRetryBudget(fraction float64, window time.Duration) *retryBudget {
+	return &retryBudget{
+		fraction: fraction,
+		window:   window,
+		buckets:  make(map[int64]*budgetBucket),
+	}
+}
+
+func (b *retryBudget) pruneLocked(now time.Time) {
+	cutoff := now.Add(-b.window).Unix()
+	for k := range b.buckets {
+		if k < cutoff {
+			delete(b.buckets, k)
+		}
+	}
+}
+
+func (b *retryBudget) totalsLocked(now time.Time) (total, hedged int) {
+	cutoff := now.Add(-b.window).Unix()
+	for k, bucket := range b.buckets {
+		if k < cutoff {
+			continue
+		}
+		total += bucket.total
+		hedged += bucket.hedged
+	}
+	return
+}
+
+// recordAttempt marks that a request - original or hedged - was sent.
+func (b *retryBudget) recordAttempt(hedged bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.pruneLocked(now)
+
+	key := now.Unix()
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &budgetBucket{}
+		b.buckets[key] = bucket
+	}
+	bucket.total++
+	if hedged {
+		bucket.hedged++
+	}
+}
+
+// allowHedge reports whether one more hedged request would stay within the
+// configured fraction of total traffic over the window.
+func (b *retryBudget) allowHedge() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.pruneLocked(now)
+	total, hedged := b.totalsLocked(now)
+	if hedged < minHedgesPerWindow {
+		return true
+	}
+	return float64(hedged+1) <= b.fraction*float64(total+1)
+}
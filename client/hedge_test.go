@@ -0,0 +1,90 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyWindowPercentile(t *testing.T) {
+	w := newLatencyWindow()
+	if got := w.percentile(0.95); got != 0 {
+		t.Fatalf("percentile on empty window = %v, want 0", got)
+	}
+
+	for ms := 1; ms <= 100; ms++ {
+		w.observe(time.Duration(ms) * time.Millisecond)
+	}
+
+	p50 := w.percentile(0.5)
+	if p50 < 45*time.Millisecond || p50 > 55*time.Millisecond {
+		t.Fatalf("p50 = %v, want ~50ms", p50)
+	}
+}
+
+func TestLatencyWindowWrapsAtCapacity(t *testing.T) {
+	w := newLatencyWindow()
+	for i := 0; i < latencyWindowSize; i++ {
+		w.observe(time.Second)
+	}
+	// push the window past capacity with a very different value; the
+	// oldest samples should be overwritten rather than the slice growing
+	// unbounded.
+	overwritten := latencyWindowSize*3/4
+	for i := 0; i < overwritten; i++ {
+		w.observe(time.Millisecond)
+	}
+
+	if len(w.samples) != latencyWindowSize {
+		t.Fatalf("len(samples) = %d, want capped at %d", len(w.samples), latencyWindowSize)
+	}
+
+	p50 := w.percentile(0.5)
+	if p50 != time.Millisecond {
+		t.Fatalf("p50 after wraparound = %v, want %v (%d/%d samples overwritten)", p50, time.Millisecond, overwritten, latencyWindowSize)
+	}
+}
+
+func TestRetryBudgetAllowsWithinFraction(t *testing.T) {
+	b := newRetryBudget(0.5, time.Second)
+
+	b.recordAttempt(false)
+	if !b.allowHedge() {
+		t.Fatal("allowHedge() = false with zero hedges recorded against 1 original")
+	}
+
+	b.recordAttempt(true)
+	// one hedge against two total attempts is already at the 0.5 fraction;
+	// one more would push hedged/total over it.
+	if b.allowHedge() {
+		t.Fatal("allowHedge() = true, want false once the fraction would be exceeded")
+	}
+}
+
+func TestRetryBudgetPrunesOldBuckets(t *testing.T) {
+	// buckets are whole seconds, so the window has to span at least one
+	b := newRetryBudget(0.1, time.Second)
+	b.recordAttempt(false)
+
+	time.Sleep(2 * time.Second)
+
+	total, hedged := b.totalsLocked(time.Now())
+	if total != 0 || hedged != 0 {
+		t.Fatalf("totals after window expiry = (%d, %d), want (0, 0)", total, hedged)
+	}
+}
+
+func TestHedgeIntervalFallsBackWithoutSamples(t *testing.T) {
+	hedge := newHedgePolicy(0.95, 0.1, 2)
+
+	if got := hedgeInterval(hedge, 0); got != defaultHedgeFallback {
+		t.Fatalf("hedgeInterval with no retry and no samples = %v, want %v", got, defaultHedgeFallback)
+	}
+	if got := hedgeInterval(hedge, 20*time.Millisecond); got != 20*time.Millisecond {
+		t.Fatalf("hedgeInterval with a retry interval and no samples = %v, want %v", got, 20*time.Millisecond)
+	}
+
+	hedge.latencies.observe(5 * time.Millisecond)
+	if got := hedgeInterval(hedge, 20*time.Millisecond); got != 5*time.Millisecond {
+		t.Fatalf("hedgeInterval once samples exist = %v, want the observed latency", got)
+	}
+}
@@ -3,9 +3,13 @@ package client
 import (
 	"github.com/4ad/doozer"
 	"github.com/bketelsen/skynet"
+	"github.com/bketelsen/skynet/lb"
 	"github.com/bketelsen/skynet/pools"
 	"github.com/bketelsen/skynet/service"
+	"golang.org/x/net/context"
 	"launchpad.net/mgo/v2/bson"
+	"net/rpc"
+	"sync"
 	"time"
 )
 
@@ -18,35 +22,50 @@ type ServiceClient struct {
 	Log     skynet.Logger `json:"-"`
 	cconfig *skynet.ClientConfig
 	query   *Query
+
+	// mu guards instances, balancer, and hedge: the mux goroutine mutates
+	// instances and balancer as instances come and go, SetBalancer and
+	// SetHedgePolicy can be called from any caller goroutine, and every
+	// in-flight attemptSend reads balancer and hedge concurrently with all
+	// of the above.
+	mu sync.RWMutex
 	// a list of the known instances
 	instances map[string]*servicePool
-	// a pool of the available instances. contains things of type servicePool
-	instancePool *pools.ResourcePool
-	muxChan      chan interface{}
-	timeoutChan  chan timeoutLengths
+	// balancer chooses which known instance serves the next request. Defaults
+	// to round-robin; override with SetBalancer.
+	balancer lb.Balancer
+	// hedge, when set via SetHedgePolicy, replaces the fixed retry ticker in
+	// send with adaptive hedging.
+	hedge       *hedgePolicy
+	muxChan     chan interface{}
+	timeoutChan chan timeoutLengths
 
 	instanceListener *InstanceListener
 	listenID         string
 
 	retryTimeout  time.Duration
 	giveupTimeout time.Duration
+
+	muxCtx    context.Context
+	muxCancel context.CancelFunc
+	muxDone   chan struct{}
 }
 
 func newServiceClient(query *Query, c *Client) (sc *ServiceClient) {
 	sc = &ServiceClient{
-		client:       c,
-		Log:          c.Config.Log,
-		cconfig:      c.Config,
-		query:        query,
-		instances:    make(map[string]*servicePool),
-		instancePool: pools.NewResourcePool(func() (pools.Resource, error) { panic("unreachable") }, -1, 0),
-		muxChan:      make(chan interface{}),
-		timeoutChan:  make(chan timeoutLengths),
+		client:      c,
+		Log:         c.Config.Log,
+		cconfig:     c.Config,
+		query:       query,
+		instances:   make(map[string]*servicePool),
+		balancer:    lb.NewRoundRobin(),
+		muxChan:     make(chan interface{}),
+		timeoutChan: make(chan timeoutLengths),
 	}
 	sc.listenID = skynet.UUID()
 	sc.instanceListener = c.instanceMonitor.Listen(sc.listenID, query)
 
-	go sc.mux()
+	sc.Serve(context.Background())
 	return
 }
 
@@ -65,18 +84,34 @@ type servicePool struct {
 	service *service.Service
 	pool    *pools.ResourcePool
 	closed  bool
+
+	breaker     *circuitBreaker
+	breakerOnce sync.Once
+}
+
+// circuit lazily creates the servicePool's circuit breaker; servicePools are
+// built by Client.getServicePool, so the breaker can't be wired in there.
+func (sp *servicePool) circuit() *circuitBreaker {
+	sp.breakerOnce.Do(func() {
+		sp.breaker = newCircuitBreaker()
+	})
+	return sp.breaker
 }
 
-// this is here to make it a pools.Resource
 func (sp *servicePool) Close() {
 	sp.closed = true
 }
 
-// this is here to make it a pools.Resource
 func (sp *servicePool) IsClosed() bool {
 	return sp.closed
 }
 
+// Key identifies the servicePool to an lb.Balancer; it's the address of the
+// instance the pool holds connections to.
+func (sp *servicePool) Key() string {
+	return sp.service.Config.ServiceAddr.String()
+}
+
 type timeoutLengths struct {
 	retry, giveup time.Duration
 }
@@ -84,11 +119,17 @@ type timeoutLengths struct {
 func (c *ServiceClient) addInstanceMux(instance *service.Service) {
 	m := service.ServiceDiscovered{instance}
 	key := m.Service.Config.ServiceAddr.String()
+
+	c.mu.Lock()
 	_, known := c.instances[key]
 	if !known {
 		// we got a new pool, put it into the wild
 		c.instances[key] = c.client.getServicePool(m.Service)
-		c.instancePool.Release(c.instances[key])
+		c.balancer.Add(c.instances[key])
+	}
+	c.mu.Unlock()
+
+	if !known {
 		c.Log.Item(m)
 	}
 }
@@ -96,12 +137,19 @@ func (c *ServiceClient) addInstanceMux(instance *service.Service) {
 func (c *ServiceClient) removeInstanceMux(instance *service.Service) {
 	m := service.ServiceRemoved{instance}
 	key := m.Service.Config.ServiceAddr.String()
-	_, known := c.instances[key]
+
+	c.mu.Lock()
+	sp, known := c.instances[key]
+	if known {
+		c.balancer.Remove(sp)
+		sp.Close()
+		delete(c.instances, key)
+	}
+	c.mu.Unlock()
+
 	if !known {
 		return
 	}
-	c.instances[key].Close()
-	delete(c.instances, m.Service.Config.ServiceAddr.String())
 	c.Log.Item(m)
 }
 
@@ -109,6 +157,8 @@ func (c *ServiceClient) mux() {
 
 	for {
 		select {
+		case <-c.muxCtx.Done():
+			return
 		case ns := <-c.instanceListener.NotificationChan:
 			for _, n := range ns {
 				switch n.Type {
@@ -131,6 +181,88 @@ func (c *ServiceClient) mux() {
 	}
 }
 
+const muxRestartBackoff = time.Second
+
+// muxPanic is logged via ServiceClient.Log whenever the mux loop panics and
+// gets restarted, so a bug in instance bookkeeping shows up in the logs
+// instead of silently swallowing notifications.
+type muxPanic struct {
+	Name      string
+	Recovered interface{}
+}
+
+func (c *ServiceClient) runMux() {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Log.Item(muxPanic{Name: "ServiceClient.mux", Recovered: r})
+		}
+	}()
+	c.mux()
+}
+
+// superviseMux runs mux, following the supervisor-tree pattern: a panic
+// inside one generation is recovered and logged, and a fresh generation is
+// started after muxRestartBackoff rather than taking the process down or
+// leaving instance discovery silently dead.
+func (c *ServiceClient) superviseMux() {
+	defer close(c.muxDone)
+
+	for c.muxCtx.Err() == nil {
+		c.runMux()
+
+		select {
+		case <-c.muxCtx.Done():
+			return
+		case <-time.After(muxRestartBackoff):
+		}
+	}
+}
+
+/*
+ServiceClient.Serve() (re)starts the supervised mux loop under ctx. It's
+called automatically when a ServiceClient is created; callers only need it
+to restart a client previously shut down with Stop or Close.
+*/
+// No test covers Serve/Stop/Close here: mux reads
+// c.instanceListener.NotificationChan on every iteration, and
+// InstanceListener has no zero-value-safe construction path in this
+// package, so a bare ServiceClient would nil-panic the moment mux ran.
+func (c *ServiceClient) Serve(ctx context.Context) {
+	c.muxCtx, c.muxCancel = context.WithCancel(ctx)
+	c.muxDone = make(chan struct{})
+	go c.superviseMux()
+}
+
+/*
+ServiceClient.Stop() cancels the mux loop and waits for it to exit. Known
+instances and their connection pools are left alone; use Close to tear those
+down too.
+*/
+func (c *ServiceClient) Stop() {
+	c.muxCancel()
+	<-c.muxDone
+}
+
+/*
+ServiceClient.Close() stops the mux loop, unregisters this client's listenID
+from the instanceMonitor, and closes every known instance's connection pool,
+so a ServiceClient that's no longer needed doesn't leak the mux goroutine,
+the instance listener registration, or open rpcClient connections.
+*/
+func (c *ServiceClient) Close() {
+	c.Stop()
+
+	c.client.instanceMonitor.RemoveListener(c.listenID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, sp := range c.instances {
+		sp.pool.Close()
+		sp.Close()
+		delete(c.instances, key)
+	}
+}
+
 /*
 ServiceClient.SetTimeout() sets the time before ServiceClient.Send() retries requests, and
 the time before ServiceClient.Send() and ServiceClient.SendOnce() give up. Setting retry
@@ -149,8 +281,50 @@ func (c *ServiceClient) GetTimeout() (retry, giveup time.Duration) {
 	return
 }
 
-// ServiceClient.sendToInstance() tries to make an RPC request on a particular connection to an instance
-func (c *ServiceClient) sendToInstance(sr ServiceResource, requestInfo *skynet.RequestInfo, funcName string, in interface{}) (result []byte, err error) {
+/*
+ServiceClient.SetBalancer() replaces the strategy used to choose which known
+instance serves the next request (lb.NewRoundRobin() by default) with b. Any
+instances already discovered are registered with b immediately; requests
+already in flight keep using whichever instance they were given.
+*/
+func (c *ServiceClient) SetBalancer(b lb.Balancer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, sp := range c.instances {
+		b.Add(sp)
+	}
+	c.balancer = b
+}
+
+// getBalancer returns the current balancer under a read lock, so callers
+// always see a consistent value even while SetBalancer or the mux loop are
+// updating it concurrently.
+func (c *ServiceClient) getBalancer() lb.Balancer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.balancer
+}
+
+// getHedge returns the current hedge policy (nil if hedging is disabled)
+// under a read lock, for the same reason as getBalancer.
+func (c *ServiceClient) getHedge() *hedgePolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hedge
+}
+
+// ServiceClient.sendToInstance() tries to make an RPC request on a particular connection to an instance.
+// If ctx is canceled or its deadline expires before the RPC returns, the connection is closed to unblock
+// the in-flight Call rather than leaving it to return on its own time. This is local-only: skynet.RequestInfo
+// has no deadline field and service.Forward has no way to learn about ctx, so the remote keeps forwarding
+// the call until it finishes on its own; closing the connection only stops this client from waiting on it.
+//
+// Payloads are always BSON. chunk0-5 (pluggable per-client codecs) is
+// explicitly descoped from this series: it needs a ContentType field on
+// service.ServiceRPCIn and a matching decoder registry on the service side,
+// and this series doesn't touch the service package. Revisit once that
+// service-side half lands.
+func (c *ServiceClient) sendToInstance(ctx context.Context, sr ServiceResource, requestInfo *skynet.RequestInfo, funcName string, in interface{}) (result []byte, err error) {
 	if requestInfo == nil {
 		requestInfo = &skynet.RequestInfo{
 			RequestID: skynet.UUID(),
@@ -169,7 +343,19 @@ func (c *ServiceClient) sendToInstance(sr ServiceResource, requestInfo *skynet.R
 
 	sout := service.ServiceRPCOut{}
 
-	err = sr.rpcClient.Call(sr.service.Config.Name+".Forward", sin, &sout)
+	call := sr.rpcClient.Go(sr.service.Config.Name+".Forward", sin, &sout, make(chan *rpc.Call, 1))
+
+	select {
+	case <-ctx.Done():
+		// the caller gave up on us; close the connection so the blocked Call
+		// returns instead of leaking an rpcClient until the remote replies.
+		sr.Close()
+		err = ctx.Err()
+		return
+	case <-call.Done:
+		err = call.Error
+	}
+
 	if err != nil {
 		sr.Close()
 		c.Log.Item(err)
@@ -189,39 +375,108 @@ type sendAttempt struct {
 	err    error
 }
 
-func (c *ServiceClient) attemptSend(attempts chan sendAttempt, ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) {
+func (c *ServiceClient) attemptSend(ctx context.Context, attempts chan sendAttempt, ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) {
+	reqKey := ""
+	if ri != nil {
+		reqKey = ri.RequestID
+	}
+
+	balancer := c.getBalancer()
+
 	// first find an available instance
-	spr, _ := c.instancePool.Acquire()
-	sp := spr.(*servicePool)
-	defer c.instancePool.Release(sp)
+	inst, err := balancer.Get(reqKey)
+	if err != nil {
+		c.Log.Item(err)
+		select {
+		case attempts <- sendAttempt{err: err}:
+		case <-ctx.Done():
+		}
+		return
+	}
+	sp := inst.(*servicePool)
+
+	if !sp.circuit().allow() {
+		err := ErrCircuitOpen
+		// balancer.Get already ran stats.begin() (inFlight++) for inst; since
+		// we're skipping it without ever calling sendToInstance, Done has to
+		// run here too or the in-flight count leaks upward forever on every
+		// open-circuit skip, permanently inflating this instance's load for
+		// LeastLoaded/PowerOfTwoChoices/ConsistentHash even after it recovers.
+		balancer.Done(inst, lb.Feedback{Err: err})
+		select {
+		case attempts <- sendAttempt{err: err}:
+		case <-ctx.Done():
+		}
+		return
+	}
 
 	// then, get a connection to that instance
 	r, err := sp.pool.Acquire()
 	defer sp.pool.Release(r)
 	if err != nil {
 		c.Log.Item(err)
+		balancer.Done(inst, lb.Feedback{Err: err})
+		c.recordCircuitResult(sp, true)
 		attempts <- sendAttempt{err: err}
 		return
 	}
 
 	sr := r.(ServiceResource)
 
-	result, err := c.sendToInstance(sr, ri, fn, in)
+	started := time.Now()
+	result, err := c.sendToInstance(ctx, sr, ri, fn, in)
+	elapsed := time.Since(started)
+	balancer.Done(inst, lb.Feedback{Err: err, Duration: elapsed})
+	// only feed successful attempts into the hedge latency window: a
+	// canceled attempt's elapsed time reflects how fast it got interrupted,
+	// not how fast the instance actually answers, and under hedging the
+	// losing attempt is always canceled the instant a sibling wins. Letting
+	// those short elapsed values in would drag the observed P95 down and
+	// make hedgeInterval fire hedges ever sooner.
+	if hedge := c.getHedge(); hedge != nil && err == nil {
+		hedge.latencies.observe(elapsed)
+	}
+
+	// a serviceError is the remote method returning an application-level
+	// error; the transport itself is fine, so it shouldn't trip the breaker.
+	// A context.Canceled/DeadlineExceeded is the caller giving up - under
+	// hedging the losing attempt is always canceled the moment a sibling
+	// wins, and a client deadline shorter than normal latency would
+	// otherwise trip every instance's breaker at once - so it doesn't
+	// reflect on the instance either.
+	_, isServiceErr := err.(serviceError)
+	isCtxErr := err == context.Canceled || err == context.DeadlineExceeded
+	c.recordCircuitResult(sp, err != nil && !isServiceErr && !isCtxErr)
+
+	select {
+	case attempts <- sendAttempt{result: result, err: err}:
+	case <-ctx.Done():
+		// caller has already moved on, nothing left to deliver this to
+	}
+}
 
-	attempts <- sendAttempt{
-		result: result,
-		err:    err,
+// recordCircuitResult feeds the outcome of a transport-level attempt into
+// sp's circuit breaker and logs any resulting state transition.
+func (c *ServiceClient) recordCircuitResult(sp *servicePool, transportErr bool) {
+	from, to := sp.circuit().record(transportErr)
+	if from == to {
+		return
 	}
+	c.Log.Item(CircuitStateChange{
+		Instance: sp.Key(),
+		From:     from.String(),
+		To:       to.String(),
+	})
 }
 
 /*
-ServiceClient.SendOnce() will send a request to one of the available instances. In intervals of retry time,
+ServiceClient.Send() will send a request to one of the available instances. In intervals of retry time,
 it will send additional requests to other known instances. If no response is heard after
 the giveup time has passed, it will return an error.
 */
 func (c *ServiceClient) Send(ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) (err error) {
 	retry, giveup := c.GetTimeout()
-	return c.send(retry, giveup, ri, fn, in, out)
+	return c.send(context.Background(), retry, giveup, ri, fn, in, out)
 }
 
 /*
@@ -230,29 +485,84 @@ the giveup time has passed, it will return an error.
 */
 func (c *ServiceClient) SendOnce(ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) (err error) {
 	_, giveup := c.GetTimeout()
-	return c.send(0, giveup, ri, fn, in, out)
+	return c.send(context.Background(), 0, giveup, ri, fn, in, out)
+}
+
+/*
+ServiceClient.SendContext() behaves like Send(), except that ctx takes precedence over the client's
+configured giveup timeout: the send is abandoned as soon as ctx is done, and a deadline set on ctx is
+honored even if it is shorter than the giveup timeout.
+*/
+func (c *ServiceClient) SendContext(ctx context.Context, ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) (err error) {
+	retry, giveup := c.GetTimeout()
+	return c.send(ctx, retry, giveup, ri, fn, in, out)
 }
 
-func (c *ServiceClient) send(retry, giveup time.Duration, ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) (err error) {
+/*
+ServiceClient.SendOnceContext() behaves like SendOnce(), except that ctx takes precedence over the
+client's configured giveup timeout in the same way as SendContext().
+*/
+func (c *ServiceClient) SendOnceContext(ctx context.Context, ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) (err error) {
+	_, giveup := c.GetTimeout()
+	return c.send(ctx, 0, giveup, ri, fn, in, out)
+}
+
+func (c *ServiceClient) send(ctx context.Context, retry, giveup time.Duration, ri *skynet.RequestInfo, fn string, in interface{}, out interface{}) (err error) {
+	// a giveup timeout is just a deadline on ctx; if the caller already supplied
+	// a deadline, the earlier of the two wins as usual for context.WithDeadline.
+	// Either way ctx ends up canceled when send returns, even with no giveup
+	// configured (SetTimeout's giveup == 0 means "never time out", not "never
+	// cancel") - otherwise a retry or hedge attempt still in flight when send
+	// returns has nothing to ever unblock its attempts<- send on.
+	var cancel context.CancelFunc
+	if giveup > 0 {
+		ctx, cancel = context.WithTimeout(ctx, giveup)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
 	attempts := make(chan sendAttempt)
 
+	hedge := c.getHedge()
+	launch := func(hedged bool) {
+		if hedge != nil {
+			hedge.budget.recordAttempt(hedged)
+		}
+		go c.attemptSend(ctx, attempts, ri, fn, in, out)
+	}
+
+	// with hedging enabled the fixed ticker steps aside for a timer that's
+	// re-armed to the current tail latency every time it fires
 	var ticker <-chan time.Time
-	if retry > 0 {
-		ticker = time.NewTicker(retry).C
+	if hedge == nil && retry > 0 {
+		t := time.NewTicker(retry)
+		defer t.Stop()
+		ticker = t.C
 	}
 
-	var timeout <-chan time.Time
-	if giveup > 0 {
-		timeout = time.NewTimer(giveup).C
+	var hedgeTimer *time.Timer
+	var hedgesFired int
+	var hedgeChan <-chan time.Time
+	if hedge != nil {
+		hedgeTimer = time.NewTimer(hedgeInterval(hedge, retry))
+		defer hedgeTimer.Stop()
+		hedgeChan = hedgeTimer.C
 	}
 
-	go c.attemptSend(attempts, ri, fn, in, out)
+	launch(false)
 
 	for {
 		select {
 		case <-ticker:
-			go c.attemptSend(attempts, ri, fn, in, out)
-		case <-timeout:
+			launch(false)
+		case <-hedgeChan:
+			if hedgesFired < hedge.maxHedges && hedge.budget.allowHedge() {
+				hedgesFired++
+				launch(true)
+			}
+			hedgeTimer.Reset(hedgeInterval(hedge, retry))
+		case <-ctx.Done():
 			if err == nil {
 				err = ErrRequestTimeout
 			}
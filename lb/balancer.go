@@ -0,0 +1,172 @@
+// Package lb provides pluggable strategies for choosing which of a service's
+// known instances should serve the next request. skynet/client uses these to
+// replace the old FIFO round-robin over a pools.ResourcePool.
+package lb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Instance is anything a Balancer can hand out: one known, addressable
+// backing instance of a service.
+type Instance interface {
+	// Key uniquely identifies the instance, e.g. its service address. It is
+	// what ConsistentHash and error/RTT bookkeeping key their state on.
+	Key() string
+}
+
+// Feedback is reported back to a Balancer once a request handed out by Get
+// has finished, so strategies that adapt to runtime conditions have
+// something to adapt to.
+type Feedback struct {
+	Err      error
+	Duration time.Duration
+}
+
+/*
+Balancer picks which known Instance should serve the next request. Add and
+Remove track the instances currently available; Get selects one of them, and
+Done reports how that pick turned out. Implementations are called from many
+goroutines at once (Get/Done from every in-flight send, Add/Remove from the
+client's instance-discovery loop) and must be safe for concurrent use.
+*/
+type Balancer interface {
+	// Add registers a newly discovered instance.
+	Add(i Instance)
+	// Remove drops an instance that is no longer available. Safe to call
+	// even if the instance was never Added.
+	Remove(i Instance)
+	// Get selects an instance to serve the next request. reqKey is
+	// consulted by key-affine strategies such as ConsistentHash; strategies
+	// that don't care about affinity ignore it. Get returns an error only
+	// when there are no instances to choose from.
+	Get(reqKey string) (Instance, error)
+	// Done reports the outcome of a request previously handed out by Get.
+	Done(i Instance, fb Feedback)
+}
+
+// ErrNoInstances is returned by Get when a Balancer has no instances to
+// choose from.
+type ErrNoInstances struct{}
+
+func (ErrNoInstances) Error() string {
+	return "lb: no instances available"
+}
+
+// stats is the per-instance bookkeeping shared by the strategies that need
+// more than a plain list: in-flight count, error rate, and observed RTT. The
+// error rate counts every request since the instance was Added; older
+// outcomes are not decayed.
+type stats struct {
+	inFlight int64
+	errors   int64
+	total    int64
+	rtt      time.Duration
+}
+
+func (s *stats) begin() {
+	atomic.AddInt64(&s.inFlight, 1)
+}
+
+func (s *stats) observe(fb Feedback) {
+	atomic.AddInt64(&s.inFlight, -1)
+	atomic.AddInt64(&s.total, 1)
+	if fb.Err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+	if fb.Duration > 0 {
+		// cheap exponential moving average, no history kept
+		cur := time.Duration(atomic.LoadInt64((*int64)(&s.rtt)))
+		if cur == 0 {
+			cur = fb.Duration
+		} else {
+			cur = (cur*4 + fb.Duration) / 5
+		}
+		atomic.StoreInt64((*int64)(&s.rtt), int64(cur))
+	}
+}
+
+func (s *stats) load() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+func (s *stats) errorRate() float64 {
+	total := atomic.LoadInt64(&s.total)
+	if total == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&s.errors)) / float64(total)
+}
+
+func (s *stats) recentRTT() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&s.rtt)))
+}
+
+// score combines in-flight load, error rate, and recent RTT into a
+// single value load-aware strategies (LeastLoaded, PowerOfTwoChoices) can
+// minimize: lower is better. Load dominates since it's the most immediate
+// signal of how busy an instance is right now, while errorRate and RTT
+// penalize instances that are technically idle but unhealthy or slow, so a
+// breaker-tripping instance doesn't look attractive just because its
+// in-flight count dropped to zero.
+func (s *stats) score() float64 {
+	load := float64(s.load())
+	errRate := s.errorRate()
+	rtt := s.recentRTT()
+	return load*10 + errRate*5 + rtt.Seconds()
+}
+
+type registry struct {
+	mu        sync.Mutex
+	instances []Instance
+	stats     map[string]*stats
+}
+
+func newRegistry() *registry {
+	return &registry{stats: make(map[string]*stats)}
+}
+
+func (r *registry) add(i Instance) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.instances {
+		if existing.Key() == i.Key() {
+			return
+		}
+	}
+	r.instances = append(r.instances, i)
+	r.stats[i.Key()] = &stats{}
+}
+
+func (r *registry) remove(i Instance) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx, existing := range r.instances {
+		if existing.Key() == i.Key() {
+			r.instances = append(r.instances[:idx], r.instances[idx+1:]...)
+			break
+		}
+	}
+	delete(r.stats, i.Key())
+}
+
+func (r *registry) snapshot() []Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Instance, len(r.instances))
+	copy(out, r.instances)
+	return out
+}
+
+func (r *registry) statsFor(i Instance) *stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.stats[i.Key()]
+	if !ok {
+		s = &stats{}
+		r.stats[i.Key()] = s
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package lb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type testInstance string
+
+func (t testInstance) Key() string { return string(t) }
+
+func TestRoundRobinCyclesAndTracksLoad(t *testing.T) {
+	b := NewRoundRobin()
+	a, c := testInstance("a"), testInstance("b")
+	b.Add(a)
+	b.Add(c)
+
+	got, err := b.Get("")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != a {
+		t.Fatalf("first Get = %v, want %v", got, a)
+	}
+
+	got, err = b.Get("")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != c {
+		t.Fatalf("second Get = %v, want %v", got, c)
+	}
+
+	if load := b.reg.statsFor(a).load(); load != 1 {
+		t.Fatalf("a load = %d, want 1", load)
+	}
+
+	b.Done(a, Feedback{})
+	if load := b.reg.statsFor(a).load(); load != 0 {
+		t.Fatalf("a load after Done = %d, want 0", load)
+	}
+}
+
+func TestRandomTracksLoad(t *testing.T) {
+	b := NewRandom()
+	a := testInstance("a")
+	b.Add(a)
+
+	got, err := b.Get("")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != a {
+		t.Fatalf("Get = %v, want %v", got, a)
+	}
+	if load := b.reg.statsFor(a).load(); load != 1 {
+		t.Fatalf("load after Get = %d, want 1", load)
+	}
+
+	b.Done(a, Feedback{})
+	if load := b.reg.statsFor(a).load(); load != 0 {
+		t.Fatalf("load after Done = %d, want 0", load)
+	}
+}
+
+func TestLeastLoadedPrefersLowerScore(t *testing.T) {
+	b := NewLeastLoaded()
+	busy, idle := testInstance("busy"), testInstance("idle")
+	b.Add(busy)
+	b.Add(idle)
+
+	// drive busy's in-flight count up without releasing it
+	if _, err := b.Get(""); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b.reg.statsFor(busy).begin()
+	b.reg.statsFor(busy).begin()
+
+	got, err := b.Get("")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != idle {
+		t.Fatalf("Get = %v, want %v (lower score)", got, idle)
+	}
+}
+
+func TestLeastLoadedPenalizesErrorsAndRTT(t *testing.T) {
+	b := NewLeastLoaded()
+	flaky, healthy := testInstance("flaky"), testInstance("healthy")
+	b.Add(flaky)
+	b.Add(healthy)
+
+	// equalize in-flight load, but give flaky a bad recent record
+	b.reg.statsFor(flaky).observe(Feedback{Err: errors.New("boom"), Duration: 500 * time.Millisecond})
+	b.reg.statsFor(healthy).observe(Feedback{Duration: 5 * time.Millisecond})
+
+	got, err := b.Get("")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != healthy {
+		t.Fatalf("Get = %v, want %v (better error rate/RTT)", got, healthy)
+	}
+}
+
+func TestPowerOfTwoChoicesSkipsEmptyAndSingle(t *testing.T) {
+	b := NewPowerOfTwoChoices()
+	if _, err := b.Get(""); err == nil {
+		t.Fatal("Get on empty registry should error")
+	}
+
+	only := testInstance("only")
+	b.Add(only)
+	got, err := b.Get("")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != only {
+		t.Fatalf("Get = %v, want %v", got, only)
+	}
+}
+
+func TestConsistentHashIsStableAndRebalances(t *testing.T) {
+	b := NewConsistentHash()
+	a, c := testInstance("a"), testInstance("b")
+	b.Add(a)
+	b.Add(c)
+
+	first, err := b.Get("same-key")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b.Done(first, Feedback{})
+
+	for i := 0; i < 10; i++ {
+		got, err := b.Get("same-key")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got != first {
+			t.Fatalf("Get(%q) = %v, want stable %v", "same-key", got, first)
+		}
+		b.Done(got, Feedback{})
+	}
+
+	b.Remove(first)
+	got, err := b.Get("same-key")
+	if err != nil {
+		t.Fatalf("Get after Remove: %v", err)
+	}
+	if got == first {
+		t.Fatalf("Get still returned removed instance %v", first)
+	}
+}
+
+func TestConsistentHashNoInstances(t *testing.T) {
+	b := NewConsistentHash()
+	if _, err := b.Get("anything"); err == nil {
+		t.Fatal("Get on empty ring should error")
+	}
+}
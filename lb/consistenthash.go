@@ -0,0 +1,87 @@
+package lb
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const defaultVirtualNodes = 160
+
+// ConsistentHash maps reqKey onto a hash ring of the known instances, so the
+// same key keeps landing on the same instance as others come and go. This is
+// the policy to reach for when instances cache per-key state and affinity
+// matters more than evenly spreading load.
+type ConsistentHash struct {
+	reg *registry
+
+	mu          sync.Mutex
+	ring        []uint32
+	ringToInst  map[uint32]Instance
+	virtualNodes int
+}
+
+func NewConsistentHash() *ConsistentHash {
+	return &ConsistentHash{
+		reg:          newRegistry(),
+		ringToInst:   make(map[uint32]Instance),
+		virtualNodes: defaultVirtualNodes,
+	}
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (b *ConsistentHash) rebuild() {
+	instances := b.reg.snapshot()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ring = b.ring[:0]
+	b.ringToInst = make(map[uint32]Instance, len(instances)*b.virtualNodes)
+	for _, i := range instances {
+		for v := 0; v < b.virtualNodes; v++ {
+			h := hashKey(i.Key() + "#" + string(rune(v)))
+			b.ring = append(b.ring, h)
+			b.ringToInst[h] = i
+		}
+	}
+	sort.Slice(b.ring, func(x, y int) bool { return b.ring[x] < b.ring[y] })
+}
+
+func (b *ConsistentHash) Add(i Instance) {
+	b.reg.add(i)
+	b.rebuild()
+}
+
+func (b *ConsistentHash) Remove(i Instance) {
+	b.reg.remove(i)
+	b.rebuild()
+}
+
+func (b *ConsistentHash) Get(reqKey string) (Instance, error) {
+	b.mu.Lock()
+	if len(b.ring) == 0 {
+		b.mu.Unlock()
+		return nil, ErrNoInstances{}
+	}
+
+	h := hashKey(reqKey)
+	idx := sort.Search(len(b.ring), func(n int) bool { return b.ring[n] >= h })
+	if idx == len(b.ring) {
+		idx = 0
+	}
+	i := b.ringToInst[b.ring[idx]]
+	b.mu.Unlock()
+
+	b.reg.statsFor(i).begin()
+	return i, nil
+}
+
+func (b *ConsistentHash) Done(i Instance, fb Feedback) {
+	b.reg.statsFor(i).observe(fb)
+}
@@ -0,0 +1,38 @@
+package lb
+
+// LeastLoaded sends each request to whichever known instance currently
+// scores best on in-flight load, error rate, and recent RTT, with
+// ties broken by insertion order.
+type LeastLoaded struct {
+	reg *registry
+}
+
+func NewLeastLoaded() *LeastLoaded {
+	return &LeastLoaded{reg: newRegistry()}
+}
+
+func (b *LeastLoaded) Add(i Instance) { b.reg.add(i) }
+
+func (b *LeastLoaded) Remove(i Instance) { b.reg.remove(i) }
+
+func (b *LeastLoaded) Get(reqKey string) (Instance, error) {
+	instances := b.reg.snapshot()
+	if len(instances) == 0 {
+		return nil, ErrNoInstances{}
+	}
+
+	best := instances[0]
+	bestScore := b.reg.statsFor(best).score()
+	for _, i := range instances[1:] {
+		if score := b.reg.statsFor(i).score(); score < bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	b.reg.statsFor(best).begin()
+	return best, nil
+}
+
+func (b *LeastLoaded) Done(i Instance, fb Feedback) {
+	b.reg.statsFor(i).observe(fb)
+}
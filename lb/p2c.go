@@ -0,0 +1,46 @@
+package lb
+
+import "math/rand"
+
+// PowerOfTwoChoices samples two instances at random and picks the
+// better-scoring of the pair (see stats.score). It gets most of
+// LeastLoaded's tail-latency benefit at a fraction of the bookkeeping cost,
+// and unlike plain LeastLoaded it doesn't pile every request onto a single
+// instance the moment it looks idle.
+type PowerOfTwoChoices struct {
+	reg *registry
+}
+
+func NewPowerOfTwoChoices() *PowerOfTwoChoices {
+	return &PowerOfTwoChoices{reg: newRegistry()}
+}
+
+func (b *PowerOfTwoChoices) Add(i Instance) { b.reg.add(i) }
+
+func (b *PowerOfTwoChoices) Remove(i Instance) { b.reg.remove(i) }
+
+func (b *PowerOfTwoChoices) Get(reqKey string) (Instance, error) {
+	instances := b.reg.snapshot()
+	switch len(instances) {
+	case 0:
+		return nil, ErrNoInstances{}
+	case 1:
+		b.reg.statsFor(instances[0]).begin()
+		return instances[0], nil
+	}
+
+	a := instances[rand.Intn(len(instances))]
+	c := instances[rand.Intn(len(instances))]
+
+	best := a
+	if b.reg.statsFor(c).score() < b.reg.statsFor(a).score() {
+		best = c
+	}
+
+	b.reg.statsFor(best).begin()
+	return best, nil
+}
+
+func (b *PowerOfTwoChoices) Done(i Instance, fb Feedback) {
+	b.reg.statsFor(i).observe(fb)
+}
@@ -0,0 +1,30 @@
+package lb
+
+import "math/rand"
+
+// Random picks a uniformly random instance for each request.
+type Random struct {
+	reg *registry
+}
+
+func NewRandom() *Random {
+	return &Random{reg: newRegistry()}
+}
+
+func (b *Random) Add(i Instance) { b.reg.add(i) }
+
+func (b *Random) Remove(i Instance) { b.reg.remove(i) }
+
+func (b *Random) Get(reqKey string) (Instance, error) {
+	instances := b.reg.snapshot()
+	if len(instances) == 0 {
+		return nil, ErrNoInstances{}
+	}
+	i := instances[rand.Intn(len(instances))]
+	b.reg.statsFor(i).begin()
+	return i, nil
+}
+
+func (b *Random) Done(i Instance, fb Feedback) {
+	b.reg.statsFor(i).observe(fb)
+}
@@ -0,0 +1,39 @@
+package lb
+
+import "sync"
+
+// RoundRobin cycles through the known instances in the order they were
+// Added, the same ordering pools.ResourcePool's FIFO Release/Acquire gave
+// before this package existed.
+type RoundRobin struct {
+	reg  *registry
+	mu   sync.Mutex
+	next int
+}
+
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{reg: newRegistry()}
+}
+
+func (b *RoundRobin) Add(i Instance) { b.reg.add(i) }
+
+func (b *RoundRobin) Remove(i Instance) { b.reg.remove(i) }
+
+func (b *RoundRobin) Get(reqKey string) (Instance, error) {
+	instances := b.reg.snapshot()
+	if len(instances) == 0 {
+		return nil, ErrNoInstances{}
+	}
+
+	b.mu.Lock()
+	i := instances[b.next%len(instances)]
+	b.next++
+	b.mu.Unlock()
+
+	b.reg.statsFor(i).begin()
+	return i, nil
+}
+
+func (b *RoundRobin) Done(i Instance, fb Feedback) {
+	b.reg.statsFor(i).observe(fb)
+}